@@ -11,11 +11,24 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
 	"io"
 	"log"
 	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const EPSILON = 0.00001
@@ -113,6 +126,13 @@ func (r Ray) pointAtTime(t float64) Point {
 type SceneObject interface {
 	intersectionTime(ray *Ray) float64
 	normalAt(p Point) Vector
+	// boundingBox returns the smallest axis-aligned box enclosing the
+	// object, and false for objects (like an infinite HalfSpace) that
+	// have no finite bounds and so cannot live inside a BVH.
+	boundingBox() (BoundingBox, bool)
+	// uvAt maps a point on the object's surface to texture coordinates,
+	// each nominally in [0,1) for an ImageTextureBaseColor to sample.
+	uvAt(p Point) (u, v float64)
 }
 
 type Sphere struct {
@@ -135,6 +155,21 @@ func (s *Sphere) normalAt(p Point) Vector {
 	return p.sub(s.centre).normalized()
 }
 
+func (s *Sphere) boundingBox() (BoundingBox, bool) {
+	r := Vector{s.radius, s.radius, s.radius}
+	return BoundingBox{s.centre.subVector(r), s.centre.addVector(r)}, true
+}
+
+// uvAt uses a spherical (equirectangular) projection of the surface
+// normal: u wraps once around the equator, v runs from the north pole
+// (v=0) to the south pole (v=1).
+func (s *Sphere) uvAt(p Point) (u, v float64) {
+	n := s.normalAt(p)
+	u = math.Atan2(n.z, n.x)/(2*math.Pi) + 0.5
+	v = math.Acos(clampFloat(n.y, -1, 1)) / math.Pi
+	return
+}
+
 type HalfSpace struct {
 	point  Point
 	normal Vector
@@ -153,21 +188,280 @@ func (s *HalfSpace) normalAt(p Point) Vector {
 	return s.normal
 }
 
+func (s *HalfSpace) boundingBox() (BoundingBox, bool) {
+	return BoundingBox{}, false
+}
+
+// uvAt projects the point onto the plane's own tangent/bitangent axes, so
+// a texture tiles flatly across the surface.
+func (s *HalfSpace) uvAt(p Point) (u, v float64) {
+	tangent, bitangent := tangentBasis(s.normal)
+	rel := p.sub(s.point)
+	return rel.dot(tangent), rel.dot(bitangent)
+}
+
+type Triangle struct {
+	v0, v1, v2 Point
+	n0, n1, n2 *Vector // per-vertex normals for smooth shading; nil for flat shading
+}
+
+func newTriangle(v0, v1, v2 Point) *Triangle {
+	return &Triangle{v0: v0, v1: v1, v2: v2}
+}
+
+func (t *Triangle) faceNormal() Vector {
+	e1 := t.v1.sub(t.v0)
+	e2 := t.v2.sub(t.v0)
+	return e1.cross(e2).normalized()
+}
+
+// intersectionTime implements the Moller-Trumbore ray-triangle intersection
+// algorithm.
+func (t *Triangle) intersectionTime(ray *Ray) float64 {
+	e1 := t.v1.sub(t.v0)
+	e2 := t.v2.sub(t.v0)
+	p := ray.vector.cross(e2)
+	det := e1.dot(p)
+	if math.Abs(det) < EPSILON {
+		return math.NaN()
+	}
+	invDet := 1.0 / det
+	tv := ray.point.sub(t.v0)
+	u := tv.dot(p) * invDet
+	if u < 0 || u > 1 {
+		return math.NaN()
+	}
+	q := tv.cross(e1)
+	v := ray.vector.dot(q) * invDet
+	if v < 0 || u+v > 1 {
+		return math.NaN()
+	}
+	return e2.dot(q) * invDet
+}
+
+// barycentric returns the weights of v0, v1 and v2 at p, which is assumed to
+// lie in the triangle's plane.
+func (t *Triangle) barycentric(p Point) (w0, w1, w2 float64) {
+	e1 := t.v1.sub(t.v0)
+	e2 := t.v2.sub(t.v0)
+	ep := p.sub(t.v0)
+	d00 := e1.dot(e1)
+	d01 := e1.dot(e2)
+	d11 := e2.dot(e2)
+	d20 := ep.dot(e1)
+	d21 := ep.dot(e2)
+	denom := d00*d11 - d01*d01
+	w1 = (d11*d20 - d01*d21) / denom
+	w2 = (d00*d21 - d01*d20) / denom
+	w0 = 1 - w1 - w2
+	return
+}
+
+func (t *Triangle) normalAt(p Point) Vector {
+	if t.n0 == nil {
+		return t.faceNormal()
+	}
+	w0, w1, w2 := t.barycentric(p)
+	n := t.n0.scale(w0).add(t.n1.scale(w1)).add(t.n2.scale(w2))
+	return n.normalized()
+}
+
+func (t *Triangle) boundingBox() (BoundingBox, bool) {
+	min := Point{
+		math.Min(t.v0.x, math.Min(t.v1.x, t.v2.x)),
+		math.Min(t.v0.y, math.Min(t.v1.y, t.v2.y)),
+		math.Min(t.v0.z, math.Min(t.v1.z, t.v2.z)),
+	}
+	max := Point{
+		math.Max(t.v0.x, math.Max(t.v1.x, t.v2.x)),
+		math.Max(t.v0.y, math.Max(t.v1.y, t.v2.y)),
+		math.Max(t.v0.z, math.Max(t.v1.z, t.v2.z)),
+	}
+	return BoundingBox{min, max}, true
+}
+
+// uvAt uses the triangle's own barycentric weights as texture coordinates.
+// This OBJ loader doesn't carry per-vertex "vt" texture coordinates, so a
+// texture simply follows the triangle's shape rather than any UV layout.
+func (t *Triangle) uvAt(p Point) (u, v float64) {
+	_, w1, w2 := t.barycentric(p)
+	return w1, w2
+}
+
+// loadObjMesh parses a Wavefront OBJ file, triangulating any n-gon faces
+// into a fan and translating 1-based vertex/normal indices. It only
+// understands the "v", "vn" and "f" record types; everything else is
+// ignored.
+func loadObjMesh(filename string) ([]*Triangle, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseObjMesh(f)
+}
+
+func parseObjMesh(r io.Reader) ([]*Triangle, error) {
+	var vertices []Point
+	var normals []Vector
+	var triangles []*Triangle
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			p, err := parseObjVector3(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			vertices = append(vertices, Point{p.x, p.y, p.z})
+		case "vn":
+			n, err := parseObjVector3(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			normals = append(normals, n)
+		case "f":
+			vi := make([]int, len(fields)-1)
+			ni := make([]int, len(fields)-1)
+			for i, field := range fields[1:] {
+				v, n, err := parseObjFaceIndex(field)
+				if err != nil {
+					return nil, err
+				}
+				vi[i], err = resolveObjIndex(v, len(vertices))
+				if err != nil {
+					return nil, err
+				}
+				if n == 0 {
+					ni[i] = -1 // sentinel: no normal reference for this vertex
+					continue
+				}
+				ni[i], err = resolveObjIndex(n, len(normals))
+				if err != nil {
+					return nil, err
+				}
+			}
+			for i := 1; i < len(vi)-1; i++ {
+				t := newTriangle(vertices[vi[0]], vertices[vi[i]], vertices[vi[i+1]])
+				if ni[0] >= 0 && ni[i] >= 0 && ni[i+1] >= 0 {
+					n0, n1, n2 := normals[ni[0]], normals[ni[i]], normals[ni[i+1]]
+					t.n0, t.n1, t.n2 = &n0, &n1, &n2
+				}
+				triangles = append(triangles, t)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return triangles, nil
+}
+
+// resolveObjIndex turns a 1-based OBJ index (or, per the OBJ spec, a
+// negative index counting back from the end of the list seen so far) into
+// a bounds-checked 0-based slice index.
+func resolveObjIndex(idx, length int) (int, error) {
+	switch {
+	case idx > 0:
+		if idx > length {
+			return 0, fmt.Errorf("obj index %d out of range (only %d defined so far)", idx, length)
+		}
+		return idx - 1, nil
+	case idx < 0:
+		pos := length + idx
+		if pos < 0 {
+			return 0, fmt.Errorf("obj index %d out of range (only %d defined so far)", idx, length)
+		}
+		return pos, nil
+	default:
+		return 0, fmt.Errorf("obj index 0 is invalid")
+	}
+}
+
+func parseObjVector3(fields []string) (Vector, error) {
+	if len(fields) < 3 {
+		return Vector{}, fmt.Errorf("expected 3 components, got %d", len(fields))
+	}
+	x, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Vector{}, err
+	}
+	y, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Vector{}, err
+	}
+	z, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return Vector{}, err
+	}
+	return Vector{x, y, z}, nil
+}
+
+// parseObjFaceIndex parses a single "f" vertex reference of the form
+// v, v/vt or v/vt/vn, returning the 1-based vertex and normal indices
+// (normalIndex is 0 when no normal reference is present).
+func parseObjFaceIndex(field string) (vertexIndex, normalIndex int, err error) {
+	parts := strings.Split(field, "/")
+	vertexIndex, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 3 && parts[2] != "" {
+		normalIndex, err = strconv.Atoi(parts[2])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return vertexIndex, normalIndex, nil
+}
+
 type Color struct {
 	r, g, b float64
 }
 
+// Canvas is anywhere a rendered image can be plotted pixel-by-pixel and
+// then written out. newCanvas picks an implementation from a filename's
+// extension.
+type Canvas interface {
+	Plot(x, y int, c Color)
+	Save() error
+	Width() int
+	Height() int
+}
+
+// newCanvas creates a Canvas that will write to filename, choosing the
+// format from its extension (".ppm" or ".png"; no extension defaults to
+// PPM for backwards compatibility).
+func newCanvas(width, height int, filename string) (Canvas, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png":
+		return newPngCanvas(width, height, filename), nil
+	case ".ppm", "":
+		return newPpmCanvas(width, height, filename), nil
+	default:
+		return nil, fmt.Errorf("unsupported canvas format %q", filepath.Ext(filename))
+	}
+}
+
 type PpmCanvas struct {
 	width, height int
-	filenameBase  string
+	filename      string
 	bytes         []byte
 }
 
-func newPpmCanvas(width, height int, filenameBase string) *PpmCanvas {
+func newPpmCanvas(width, height int, filename string) *PpmCanvas {
+	if filepath.Ext(filename) == "" {
+		filename += ".ppm"
+	}
 	c := &PpmCanvas{
 		width,
 		height,
-		filenameBase,
+		filename,
 		make([]byte, width*height*3),
 	}
 	for i := 0; i < width*height; i++ {
@@ -186,30 +480,72 @@ func clamp(c int) int {
 	return c
 }
 
-func (a *PpmCanvas) plot(x, y int, c Color) {
+func (a *PpmCanvas) Width() int  { return a.width }
+func (a *PpmCanvas) Height() int { return a.height }
+
+func (a *PpmCanvas) Plot(x, y int, c Color) {
 	i := ((a.height-y-1)*a.width + x) * 3
 	a.bytes[i+0] = byte(clamp(int(c.r * 255)))
 	a.bytes[i+1] = byte(clamp(int(c.g * 255)))
 	a.bytes[i+2] = byte(clamp(int(c.b * 255)))
 }
 
-func (a *PpmCanvas) save() {
-	name := a.filenameBase + ".ppm"
-	os.Remove(name)
-	f, err := os.Create(name)
+func (a *PpmCanvas) Save() error {
+	os.Remove(a.filename)
+	f, err := os.Create(a.filename)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	header := fmt.Sprintf("P6\n%d %d\n255\n", a.width, a.height)
 	if _, err := io.WriteString(f, header); err != nil {
-		log.Fatal(err)
+		return err
 	}
 	if _, err := f.Write(a.bytes); err != nil {
-		log.Fatal(err)
+		return err
 	}
-	if err := f.Close(); err != nil {
-		log.Fatal(err)
+	return f.Close()
+}
+
+// PngCanvas writes a standard sRGB PNG via the stock image/png encoder.
+type PngCanvas struct {
+	width, height int
+	filename      string
+	img           *image.RGBA
+}
+
+func newPngCanvas(width, height int, filename string) *PngCanvas {
+	if filepath.Ext(filename) == "" {
+		filename += ".png"
+	}
+	return &PngCanvas{width, height, filename, image.NewRGBA(image.Rect(0, 0, width, height))}
+}
+
+func (a *PngCanvas) Width() int  { return a.width }
+func (a *PngCanvas) Height() int { return a.height }
+
+// Plot gamma-corrects the linear color to sRGB before writing the pixel,
+// since PNG viewers expect gamma-encoded bytes.
+func (a *PngCanvas) Plot(x, y int, c Color) {
+	g := gammaEncode(c)
+	a.img.SetRGBA(x, a.height-y-1, color.RGBA{
+		R: byte(clamp(int(g.r * 255))),
+		G: byte(clamp(int(g.g * 255))),
+		B: byte(clamp(int(g.b * 255))),
+		A: 255,
+	})
+}
+
+func (a *PngCanvas) Save() error {
+	os.Remove(a.filename)
+	f, err := os.Create(a.filename)
+	if err != nil {
+		return err
 	}
+	if err := png.Encode(f, a.img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 type Intersection struct {
@@ -233,7 +569,11 @@ func firstIntersection(intersections []Intersection) *Intersection {
 }
 
 type Surface interface {
-	colorAt(scene *Scene, ray *Ray, p Point, normal Vector) Color
+	// depth is the number of reflection bounces taken to reach this
+	// surface, passed explicitly (rather than stored on Scene) so that
+	// concurrent rays in flight on different goroutines don't share
+	// mutable state.
+	colorAt(scene *Scene, ray *Ray, p Point, normal Vector, depth int) Color
 }
 
 type Obj struct {
@@ -241,13 +581,215 @@ type Obj struct {
 	surface Surface
 }
 
+// BoundingBox is an axis-aligned bounding box used to accelerate ray
+// intersection tests via the BVH below.
+type BoundingBox struct {
+	min, max Point
+}
+
+func (b BoundingBox) union(other BoundingBox) BoundingBox {
+	return BoundingBox{
+		Point{math.Min(b.min.x, other.min.x), math.Min(b.min.y, other.min.y), math.Min(b.min.z, other.min.z)},
+		Point{math.Max(b.max.x, other.max.x), math.Max(b.max.y, other.max.y), math.Max(b.max.z, other.max.z)},
+	}
+}
+
+func (b BoundingBox) centroid() Point {
+	return Point{(b.min.x + b.max.x) / 2, (b.min.y + b.max.y) / 2, (b.min.z + b.max.z) / 2}
+}
+
+func (b BoundingBox) surfaceArea() float64 {
+	d := b.max.sub(b.min)
+	return 2 * (d.x*d.y + d.y*d.z + d.z*d.x)
+}
+
+// longestAxis returns 0, 1 or 2 for x, y or z, whichever the box is longest
+// along.
+func (b BoundingBox) longestAxis() int {
+	d := b.max.sub(b.min)
+	if d.x > d.y && d.x > d.z {
+		return 0
+	}
+	if d.y > d.z {
+		return 1
+	}
+	return 2
+}
+
+func axisComponent(p Point, axis int) float64 {
+	switch axis {
+	case 0:
+		return p.x
+	case 1:
+		return p.y
+	default:
+		return p.z
+	}
+}
+
+func (b BoundingBox) hit(ray *Ray) bool {
+	tmin, tmax := math.Inf(-1), math.Inf(1)
+	origin := [3]float64{ray.point.x, ray.point.y, ray.point.z}
+	dir := [3]float64{ray.vector.x, ray.vector.y, ray.vector.z}
+	bmin := [3]float64{b.min.x, b.min.y, b.min.z}
+	bmax := [3]float64{b.max.x, b.max.y, b.max.z}
+	for axis := 0; axis < 3; axis++ {
+		if dir[axis] == 0 {
+			if origin[axis] < bmin[axis] || origin[axis] > bmax[axis] {
+				return false
+			}
+			continue
+		}
+		t1 := (bmin[axis] - origin[axis]) / dir[axis]
+		t2 := (bmax[axis] - origin[axis]) / dir[axis]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		tmin = math.Max(tmin, t1)
+		tmax = math.Min(tmax, t2)
+		if tmin > tmax {
+			return false
+		}
+	}
+	return tmax >= 0
+}
+
+// bvhPrim pairs a scene object with its precomputed bounding box, for use
+// while building the tree below.
+type bvhPrim struct {
+	obj    Obj
+	bounds BoundingBox
+}
+
+// bvhLeafSize is the maximum number of primitives stored in a BVH leaf
+// node before the builder splits further.
+const bvhLeafSize = 4
+
+// BVHNode is a node of a bounding-volume hierarchy over the scene's finite
+// (boundable) objects. Interior nodes have left and right set; leaf nodes
+// have objs set instead.
+type BVHNode struct {
+	bounds      BoundingBox
+	left, right *BVHNode
+	objs        []Obj
+}
+
+// buildBVH recursively partitions prims using a surface-area-heuristic
+// split along the bounding box's longest axis, destructively reordering
+// prims in the process.
+func buildBVH(prims []bvhPrim) *BVHNode {
+	if len(prims) == 0 {
+		return nil
+	}
+	bounds := prims[0].bounds
+	for _, p := range prims[1:] {
+		bounds = bounds.union(p.bounds)
+	}
+	if len(prims) <= bvhLeafSize {
+		objs := make([]Obj, len(prims))
+		for i, p := range prims {
+			objs[i] = p.obj
+		}
+		return &BVHNode{bounds: bounds, objs: objs}
+	}
+
+	axis := bounds.longestAxis()
+	sort.Slice(prims, func(i, j int) bool {
+		return axisComponent(prims[i].bounds.centroid(), axis) < axisComponent(prims[j].bounds.centroid(), axis)
+	})
+	split := sahSplit(prims)
+	return &BVHNode{
+		bounds: bounds,
+		left:   buildBVH(prims[:split]),
+		right:  buildBVH(prims[split:]),
+	}
+}
+
+// sahSplit picks the split point (already sorted along the chosen axis)
+// that minimizes the surface-area-heuristic cost
+// leftCount*leftArea + rightCount*rightArea.
+func sahSplit(prims []bvhPrim) int {
+	n := len(prims)
+	leftBounds := make([]BoundingBox, n)
+	b := prims[0].bounds
+	leftBounds[0] = b
+	for i := 1; i < n; i++ {
+		b = b.union(prims[i].bounds)
+		leftBounds[i] = b
+	}
+	rightBounds := make([]BoundingBox, n)
+	b = prims[n-1].bounds
+	rightBounds[n-1] = b
+	for i := n - 2; i >= 0; i-- {
+		b = b.union(prims[i].bounds)
+		rightBounds[i] = b
+	}
+
+	bestCost := math.Inf(1)
+	bestSplit := n / 2
+	for i := 1; i < n; i++ {
+		cost := float64(i)*leftBounds[i-1].surfaceArea() + float64(n-i)*rightBounds[i].surfaceArea()
+		if cost < bestCost {
+			bestCost = cost
+			bestSplit = i
+		}
+	}
+	return bestSplit
+}
+
+// nearestHit returns the closest intersection along ray within the subtree
+// rooted at n, if any.
+func (n *BVHNode) nearestHit(ray *Ray) (Intersection, bool) {
+	if n == nil || !n.bounds.hit(ray) {
+		return Intersection{}, false
+	}
+	if n.objs != nil {
+		var best Intersection
+		found := false
+		for _, o := range n.objs {
+			t := o.object.intersectionTime(ray)
+			if !math.IsNaN(t) && t > -EPSILON && (!found || t < best.t) {
+				best = Intersection{o.object, t, o.surface}
+				found = true
+			}
+		}
+		return best, found
+	}
+	left, lok := n.left.nearestHit(ray)
+	right, rok := n.right.nearestHit(ray)
+	if lok && (!rok || left.t < right.t) {
+		return left, true
+	}
+	return right, rok
+}
+
+// anyHit reports whether anything in the subtree rooted at n blocks ray,
+// without bothering to find the closest one. Used for shadow tests.
+func (n *BVHNode) anyHit(ray *Ray) bool {
+	if n == nil || !n.bounds.hit(ray) {
+		return false
+	}
+	if n.objs != nil {
+		for _, o := range n.objs {
+			t := o.object.intersectionTime(ray)
+			if !math.IsNaN(t) && t > EPSILON {
+				return true
+			}
+		}
+		return false
+	}
+	return n.left.anyHit(ray) || n.right.anyHit(ray)
+}
+
 type Scene struct {
-	objects        []Obj
-	lightPoints    []Point
-	position       Point
-	lookingAt      Point
-	fieldOfView    float64
-	recursionDepth int
+	objects         []Obj
+	infiniteObjects []Obj
+	bvh             *BVHNode
+	bvhBuilt        bool
+	lightPoints     []Point
+	position        Point
+	lookingAt       Point
+	fieldOfView     float64
 }
 
 func newScene() *Scene {
@@ -255,7 +797,6 @@ func newScene() *Scene {
 	s.position = Point{0, 1.8, 10}
 	s.lookingAt = ZERO
 	s.fieldOfView = 45
-	s.recursionDepth = 0
 	return s
 }
 
@@ -269,81 +810,261 @@ func (s *Scene) lookAt(p Point) {
 
 func (s *Scene) addObject(object SceneObject, surface Surface) {
 	s.objects = append(s.objects, Obj{object, surface})
+	s.bvhBuilt = false
+}
+
+// addMesh loads the OBJ file at filename and adds every triangle in it to
+// the scene with the given surface.
+func (s *Scene) addMesh(filename string, surface Surface) error {
+	triangles, err := loadObjMesh(filename)
+	if err != nil {
+		return err
+	}
+	for _, t := range triangles {
+		s.addObject(t, surface)
+	}
+	return nil
 }
 
 func (s *Scene) addLight(p Point) {
 	s.lightPoints = append(s.lightPoints, p)
 }
 
-func (s *Scene) render(canvas *PpmCanvas) {
-	if false {
-		fmt.Printf("Computing field of view\n")
+// ensureBVH (re)builds the BVH over the scene's finite objects the first
+// time it's needed, or after addObject has added anything since the last
+// build. Objects with no finite bounding box (e.g. a HalfSpace) are kept
+// in infiniteObjects and checked by linear scan.
+func (s *Scene) ensureBVH() {
+	if s.bvhBuilt {
+		return
+	}
+	var prims []bvhPrim
+	s.infiniteObjects = nil
+	for _, o := range s.objects {
+		if bb, ok := o.object.boundingBox(); ok {
+			prims = append(prims, bvhPrim{o, bb})
+		} else {
+			s.infiniteObjects = append(s.infiniteObjects, o)
+		}
 	}
+	s.bvh = buildBVH(prims)
+	s.bvhBuilt = true
+}
+
+// Camera holds the view parameters derived from a Scene's position,
+// lookingAt and fieldOfView, so that a ray can be computed for an
+// arbitrary (possibly fractional, for supersampling) pixel coordinate.
+type Camera struct {
+	eye                   Ray
+	vpRight, vpUp         Vector
+	halfWidth, halfHeight float64
+}
+
+func (s *Scene) camera() Camera {
 	fovRadians := math.Pi * (s.fieldOfView / 2.0) / 180.0
 	halfWidth := math.Tan(fovRadians)
 	halfHeight := 0.75 * halfWidth
-	width := halfWidth * 2
-	height := halfHeight * 2
-	pixelWidth := width / float64(canvas.width-1)
-	pixelHeight := height / float64(canvas.height-1)
-
 	eye := newRay(s.position, s.lookingAt.sub(s.position))
 	vpRight := eye.vector.cross(UP).normalized()
 	vpUp := vpRight.cross(eye.vector).normalized()
+	return Camera{eye, vpRight, vpUp, halfWidth, halfHeight}
+}
 
-	fmt.Printf("Looping over pixels\n")
-	var previousfraction float32
-	for y := 0; y < canvas.height; y++ {
-		currentfraction := float32(y) / float32(canvas.height)
-		if currentfraction-previousfraction > 0.05 {
-			if false {
-				canvas.save()
+// rayThroughPixel computes the ray through pixel coordinate (px, py) of a
+// canvas width x height. px and py need not be integral, which lets callers
+// jitter sub-pixel samples for anti-aliasing or path tracing.
+func (c Camera) rayThroughPixel(px, py float64, width, height int) Ray {
+	pixelWidth := (c.halfWidth * 2) / float64(width-1)
+	pixelHeight := (c.halfHeight * 2) / float64(height-1)
+	xcomp := c.vpRight.scale(px*pixelWidth - c.halfWidth)
+	ycomp := c.vpUp.scale(py*pixelHeight - c.halfHeight)
+	return newRay(c.eye.point, c.eye.vector.add(xcomp).add(ycomp))
+}
+
+// renderTileSize is the edge length, in pixels, of the square tiles that
+// render work is divided into.
+const renderTileSize = 32
+
+// renderTile is one unit of work handed to a render worker: the half-open
+// pixel rectangle [x0,x1) x [y0,y1).
+type renderTile struct {
+	x0, y0, x1, y1 int
+}
+
+// render divides the canvas into tiles and renders them concurrently across
+// threads worker goroutines pulling off a shared channel, so idle workers
+// steal whatever tile is next rather than owning a fixed slice of the
+// image. Each tile writes a disjoint region of the canvas, so no
+// synchronization is needed there. A separate goroutine aggregates
+// completed-tile counts into a progress report.
+// render renders the scene using threads worker goroutines. aa is the
+// per-axis supersampling factor: aa=1 fires a single ray through each pixel
+// centre (the original, un-antialiased behavior); aa>1 fires an aa*aa grid
+// of rays jittered within the pixel footprint and averages them in linear
+// space, leaving any gamma encoding to the canvas (see pixelColor).
+func (s *Scene) render(canvas Canvas, threads int, aa int) {
+	camera := s.camera()
+	s.ensureBVH() // build once up front: workers must not race on a lazy build
+	width, height := canvas.Width(), canvas.Height()
+
+	var tiles []renderTile
+	for y := 0; y < height; y += renderTileSize {
+		for x := 0; x < width; x += renderTileSize {
+			tiles = append(tiles, renderTile{x, y, minInt(x+renderTileSize, width), minInt(y+renderTileSize, height)})
+		}
+	}
+
+	tileQueue := make(chan renderTile, len(tiles))
+	for _, t := range tiles {
+		tileQueue <- t
+	}
+	close(tileQueue)
+
+	tileDone := make(chan struct{}, len(tiles))
+	var workers sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for t := range tileQueue {
+				for y := t.y0; y < t.y1; y++ {
+					for x := t.x0; x < t.x1; x++ {
+						canvas.Plot(x, y, s.pixelColor(camera, x, y, width, height, aa))
+					}
+				}
+				tileDone <- struct{}{}
+			}
+		}()
+	}
+
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		completed := 0
+		var previousfraction float32
+		for range tileDone {
+			completed++
+			currentfraction := float32(completed) / float32(len(tiles))
+			if currentfraction-previousfraction > 0.05 || completed == len(tiles) {
+				fmt.Printf("%d%% complete\n", int(currentfraction*100))
+				previousfraction = currentfraction
 			}
-			fmt.Printf("%d%% complete\n", int(currentfraction*100))
-			previousfraction = currentfraction
 		}
-		for x := 0; x < canvas.width; x++ {
-			xcomp := vpRight.scale(float64(x)*pixelWidth - halfWidth)
-			ycomp := vpUp.scale(float64(y)*pixelHeight - halfHeight)
-			ray := newRay(eye.point, eye.vector.add(xcomp).add(ycomp))
-			color := s.rayColor(ray)
-			canvas.plot(x, y, color)
+	}()
+
+	workers.Wait()
+	close(tileDone)
+	<-progressDone
+
+	if err := canvas.Save(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Complete.\n")
+}
+
+// pixelColor computes the color of canvas pixel (x, y). With aa<=1 it casts
+// a single ray through the pixel centre, matching the renderer's original
+// behavior exactly. With aa>1 it stratifies aa*aa samples across the pixel
+// footprint, jitters within each cell, and averages them in linear space.
+// Averaging in linear light (rather than gamma-encoding each sample first)
+// is what actually keeps silhouette edges from darkening; gamma encoding
+// happens exactly once, at canvas write time, in whichever Canvas.Plot
+// implementation needs it.
+func (s *Scene) pixelColor(camera Camera, x, y, width, height, aa int) Color {
+	if aa <= 1 {
+		ray := camera.rayThroughPixel(float64(x), float64(y), width, height)
+		return s.rayColor(ray, 0)
+	}
+
+	var sum Color
+	cellSize := 1.0 / float64(aa)
+	for sy := 0; sy < aa; sy++ {
+		for sx := 0; sx < aa; sx++ {
+			jx := (float64(sx)+rand.Float64())*cellSize - 0.5
+			jy := (float64(sy)+rand.Float64())*cellSize - 0.5
+			ray := camera.rayThroughPixel(float64(x)+jx, float64(y)+jy, width, height)
+			sum = addColors(sum, 1, s.rayColor(ray, 0))
 		}
 	}
+	return sum.scale(1.0 / float64(aa*aa))
+}
 
-	canvas.save()
-	fmt.Printf("Complete.\n")
+// gammaEncode converts a linear-light color to sRGB-ish gamma space
+// (gamma 2.2), clamping to [0,1] first since a negative or >1 linear value
+// has no real gamma-encoded equivalent.
+func gammaEncode(c Color) Color {
+	return Color{
+		math.Pow(clampUnit(c.r), 1/2.2),
+		math.Pow(clampUnit(c.g), 1/2.2),
+		math.Pow(clampUnit(c.b), 1/2.2),
+	}
 }
 
-func (s *Scene) rayColor(ray Ray) Color {
-	if s.recursionDepth > 3 {
-		return Color{0, 0, 0}
+func clampUnit(f float64) float64 {
+	return clampFloat(f, 0, 1)
+}
+
+func clampFloat(f, lo, hi float64) float64 {
+	if f < lo {
+		return lo
+	}
+	if f > hi {
+		return hi
+	}
+	return f
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
 	}
-	s.recursionDepth++
+	return b
+}
+
+// nearestVisibleHit finds the closest intersection of ray with the scene,
+// combining the BVH over finite objects with a linear scan of the
+// (necessarily few) infinite ones.
+func (s *Scene) nearestVisibleHit(ray *Ray) (Intersection, bool) {
+	s.ensureBVH()
 	var intersections []Intersection
-	for _, o := range s.objects {
-		intersections = append(intersections, Intersection{o.object, o.object.intersectionTime(&ray), o.surface})
+	for _, o := range s.infiniteObjects {
+		intersections = append(intersections, Intersection{o.object, o.object.intersectionTime(ray), o.surface})
+	}
+	if hit, ok := s.bvh.nearestHit(ray); ok {
+		intersections = append(intersections, hit)
 	}
 	i := firstIntersection(intersections)
 	if i == nil {
-		s.recursionDepth--
+		return Intersection{}, false
+	}
+	return *i, true
+}
+
+// rayColor takes the current recursion depth as a parameter, rather than
+// storing it on Scene, so that rays cast concurrently from different
+// render goroutines don't stomp on each other's depth counter.
+func (s *Scene) rayColor(ray Ray, depth int) Color {
+	if depth > 3 {
+		return Color{0, 0, 0}
+	}
+	i, ok := s.nearestVisibleHit(&ray)
+	if !ok {
 		return Color{0, 0, 0} // the background color
-	} else {
-		p := ray.pointAtTime(i.t)
-		s.recursionDepth--
-		return i.s.colorAt(s, &ray, p, i.o.normalAt(p))
 	}
+	p := ray.pointAtTime(i.t)
+	return i.s.colorAt(s, &ray, p, i.o.normalAt(p), depth)
 }
 
 func (s *Scene) lightIsVisible(l Vector, p Point) bool {
-	for _, o := range s.objects {
-		ray := newRay(p, l.subPoint(p))
+	s.ensureBVH()
+	ray := newRay(p, l.subPoint(p))
+	for _, o := range s.infiniteObjects {
 		t := o.object.intersectionTime(&ray)
 		if !math.IsNaN(t) && t > EPSILON {
 			return false
 		}
 	}
-	return true
+	return !s.bvh.anyHit(&ray)
 }
 
 func (s *Scene) visibleLights(p Point) []Point {
@@ -360,6 +1081,14 @@ func addColors(a Color, scale float64, b Color) Color {
 	return Color{a.r + scale*b.r, a.g + scale*b.g, a.b + scale*b.b}
 }
 
+func (c Color) scale(factor float64) Color {
+	return Color{c.r * factor, c.g * factor, c.b * factor}
+}
+
+func mulColors(a, b Color) Color {
+	return Color{a.r * b.r, a.g * b.g, a.b * b.b}
+}
+
 type BaseColor interface {
 	baseColorAt(p Point) Color
 }
@@ -372,11 +1101,24 @@ func (c *UniformBaseColor) baseColorAt(p Point) Color {
 	return c.color
 }
 
+// MaterialKind selects how the path tracer samples a new direction when it
+// bounces off a SimpleSurface; it has no effect on the Whitted integrator.
+type MaterialKind int
+
+const (
+	DiffuseMaterial MaterialKind = iota
+	GlossyMaterial
+	MirrorMaterial
+)
+
 type SimpleSurface struct {
 	baseColor           BaseColor
 	specularCoefficient float64
 	lambertCoefficient  float64
 	ambientCoefficient  float64
+	emissive            Color
+	kind                MaterialKind
+	glossyExponent      float64
 }
 
 func newSimpleSurface() *SimpleSurface {
@@ -385,6 +1127,7 @@ func newSimpleSurface() *SimpleSurface {
 	s.specularCoefficient = 0.2
 	s.lambertCoefficient = 0.6
 	s.ambientCoefficient = 1.0 - s.specularCoefficient - s.lambertCoefficient
+	s.kind = DiffuseMaterial
 	return s
 }
 
@@ -393,13 +1136,37 @@ func (s *SimpleSurface) setBaseColor(c BaseColor) *SimpleSurface {
 	return s
 }
 
-func (s *SimpleSurface) colorAt(scene *Scene, ray *Ray, p Point, normal Vector) Color {
+// setEmissive marks the surface as a light source for the path tracer: c is
+// added to a ray's radiance whenever it hits the surface.
+func (s *SimpleSurface) setEmissive(c Color) *SimpleSurface {
+	s.emissive = c
+	return s
+}
+
+// setMirror makes the surface reflect perfectly under the path tracer.
+func (s *SimpleSurface) setMirror() *SimpleSurface {
+	s.kind = MirrorMaterial
+	return s
+}
+
+// setGlossy makes the surface sample a Phong lobe of the given exponent
+// around the perfect reflection direction under the path tracer.
+func (s *SimpleSurface) setGlossy(exponent float64) *SimpleSurface {
+	s.kind = GlossyMaterial
+	s.glossyExponent = exponent
+	return s
+}
+
+func (s *SimpleSurface) colorAt(scene *Scene, ray *Ray, p Point, normal Vector, depth int) Color {
 	b := s.baseColor.baseColorAt(p)
 
-	c := Color{0, 0, 0}
+	// emissive surfaces glow under both integrators: the path tracer adds
+	// it while walking a path, and the Whitted integrator adds it here
+	// since it has no equivalent walk to hang it off of.
+	c := s.emissive
 	if s.specularCoefficient > 0 {
 		reflectedRay := newRay(p, ray.vector.reflectThrough(normal))
-		reflectedColor := scene.rayColor(reflectedRay)
+		reflectedColor := scene.rayColor(reflectedRay, depth+1)
 		c = addColors(c, s.specularCoefficient, reflectedColor)
 	}
 
@@ -447,9 +1214,308 @@ func newCheckerboardSurface() *SimpleSurface {
 	return s
 }
 
+// GradientBaseColor linearly interpolates between two colors along a
+// direction: points projecting to 0 along direction get from, points
+// projecting to 1 get to, and anything outside that range is clamped.
+type GradientBaseColor struct {
+	from, to  Color
+	direction Vector
+}
+
+func newGradientBaseColor(from, to Color, direction Vector) *GradientBaseColor {
+	return &GradientBaseColor{from, to, direction.normalized()}
+}
+
+func (g *GradientBaseColor) baseColorAt(p Point) Color {
+	t := clampUnit(p.toVector().dot(g.direction))
+	return addColors(g.from.scale(1-t), t, g.to)
+}
+
+// TextureWrapMode selects how ImageTextureBaseColor handles UV coordinates
+// (or bilinear taps) that fall outside the image.
+type TextureWrapMode int
+
+const (
+	WrapRepeat TextureWrapMode = iota
+	WrapClamp
+)
+
+// ImageTextureBaseColor samples a decoded PNG/JPEG image using the UV
+// coordinates that object's uvAt produces.
+type ImageTextureBaseColor struct {
+	img      image.Image
+	object   SceneObject
+	wrap     TextureWrapMode
+	bilinear bool
+}
+
+// newImageTextureBaseColor loads filename (PNG or JPEG, detected by the
+// standard image.Decode format sniffing) and samples it via object's
+// uvAt. object should be the same SceneObject this base color is attached
+// to, since uvAt's parameterization is specific to that surface.
+func newImageTextureBaseColor(filename string, object SceneObject) (*ImageTextureBaseColor, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageTextureBaseColor{img: img, object: object, wrap: WrapRepeat, bilinear: true}, nil
+}
+
+func (c *ImageTextureBaseColor) setWrapMode(w TextureWrapMode) *ImageTextureBaseColor {
+	c.wrap = w
+	return c
+}
+
+func (c *ImageTextureBaseColor) setBilinear(bilinear bool) *ImageTextureBaseColor {
+	c.bilinear = bilinear
+	return c
+}
+
+func (c *ImageTextureBaseColor) baseColorAt(p Point) Color {
+	u, v := c.object.uvAt(p)
+	bounds := c.img.Bounds()
+	// v=0 is conventionally the bottom of a texture (v grows upward), but
+	// image coordinates grow downward, so flip it.
+	x := u*float64(bounds.Dx()) - 0.5
+	y := (1-v)*float64(bounds.Dy()) - 0.5
+
+	if !c.bilinear {
+		return c.texel(int(math.Round(x)), int(math.Round(y)))
+	}
+
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	top := addColors(c.texel(x0, y0).scale(1-fx), fx, c.texel(x0+1, y0))
+	bottom := addColors(c.texel(x0, y0+1).scale(1-fx), fx, c.texel(x0+1, y0+1))
+	return addColors(top.scale(1-fy), fy, bottom)
+}
+
+func (c *ImageTextureBaseColor) texel(x, y int) Color {
+	bounds := c.img.Bounds()
+	x = wrapCoord(c.wrap, x, bounds.Min.X, bounds.Max.X)
+	y = wrapCoord(c.wrap, y, bounds.Min.Y, bounds.Max.Y)
+	r, g, b, _ := c.img.At(x, y).RGBA()
+	return Color{float64(r) / 65535, float64(g) / 65535, float64(b) / 65535}
+}
+
+// wrapCoord maps v into [lo, hi) according to mode.
+func wrapCoord(mode TextureWrapMode, v, lo, hi int) int {
+	n := hi - lo
+	if n <= 0 {
+		return lo
+	}
+	if mode == WrapClamp {
+		return int(clampFloat(float64(v), float64(lo), float64(hi-1)))
+	}
+	m := (v - lo) % n
+	if m < 0 {
+		m += n
+	}
+	return lo + m
+}
+
+// minPathBounces is how many bounces a path always survives before Russian
+// roulette termination kicks in.
+const minPathBounces = 4
+
+// maxPathBounces is a hard cap on path length, regardless of how Russian
+// roulette rolls.
+const maxPathBounces = 8
+
+// PathTracer is a Monte Carlo integrator: an alternative to Scene.rayColor's
+// Whitted-style recursion that estimates the rendering equation by
+// stratified sampling and random walks, with next-event estimation against
+// the scene's point lights and Russian roulette path termination.
+type PathTracer struct {
+	scene *Scene
+	spp   int
+}
+
+func newPathTracer(scene *Scene, spp int) *PathTracer {
+	return &PathTracer{scene, spp}
+}
+
+func (pt *PathTracer) render(canvas Canvas) {
+	camera := pt.scene.camera()
+	grid := int(math.Ceil(math.Sqrt(float64(pt.spp))))
+	rng := rand.New(rand.NewSource(1))
+	width, height := canvas.Width(), canvas.Height()
+
+	fmt.Printf("Path tracing at %d spp\n", grid*grid)
+	var previousfraction float32
+	for y := 0; y < height; y++ {
+		currentfraction := float32(y) / float32(height)
+		if currentfraction-previousfraction > 0.05 {
+			fmt.Printf("%d%% complete\n", int(currentfraction*100))
+			previousfraction = currentfraction
+		}
+		for x := 0; x < width; x++ {
+			sum := Color{0, 0, 0}
+			for sy := 0; sy < grid; sy++ {
+				for sx := 0; sx < grid; sx++ {
+					px := float64(x) + (float64(sx)+rng.Float64())/float64(grid) - 0.5
+					py := float64(y) + (float64(sy)+rng.Float64())/float64(grid) - 0.5
+					ray := camera.rayThroughPixel(px, py, width, height)
+					sum = addColors(sum, 1, pt.samplePath(ray, rng))
+				}
+			}
+			canvas.Plot(x, y, sum.scale(1.0/float64(grid*grid)))
+		}
+	}
+
+	if err := canvas.Save(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Complete.\n")
+}
+
+// samplePath traces a single path starting at ray and returns its
+// contribution to the pixel's radiance.
+func (pt *PathTracer) samplePath(ray Ray, rng *rand.Rand) Color {
+	result := Color{0, 0, 0}
+	throughput := Color{1, 1, 1}
+
+	for bounce := 0; bounce <= maxPathBounces; bounce++ {
+		hit, ok := pt.scene.nearestVisibleHit(&ray)
+		if !ok {
+			break
+		}
+		ss, ok := hit.s.(*SimpleSurface)
+		if !ok {
+			break
+		}
+
+		p := ray.pointAtTime(hit.t)
+		normal := hit.o.normalAt(p)
+		if ray.vector.dot(normal) > 0 {
+			normal = normal.negated()
+		}
+
+		result = addColors(result, 1, mulColors(throughput, ss.emissive))
+
+		nextRay, reflectance, ok := sampleBounce(ss, p, normal, ray.vector, rng)
+		if !ok {
+			break
+		}
+		if ss.kind == DiffuseMaterial {
+			direct := pt.scene.visibleLights(p)
+			lambertAmount := 0.0
+			for _, lightPoint := range direct {
+				if c := lightPoint.sub(p).normalized().dot(normal); c > 0 {
+					lambertAmount += c
+				}
+			}
+			if lambertAmount > 1.0 {
+				lambertAmount = 1.0
+			}
+			result = addColors(result, 1, mulColors(throughput, reflectance.scale(lambertAmount)))
+		}
+
+		throughput = mulColors(throughput, reflectance)
+		ray = nextRay
+
+		if bounce >= minPathBounces {
+			p := math.Max(throughput.r, math.Max(throughput.g, throughput.b))
+			if p <= 0 {
+				break
+			}
+			if p > 1 {
+				p = 1
+			}
+			if rng.Float64() > p {
+				break
+			}
+			throughput = throughput.scale(1 / p)
+		}
+	}
+
+	return result
+}
+
+// sampleBounce picks the outgoing ray and reflectance for a bounce off ss,
+// according to its MaterialKind. incoming is the direction of the ray that
+// hit the surface. It returns ok=false when the sampled direction turns out
+// to be below the surface (can happen with the glossy lobe).
+func sampleBounce(ss *SimpleSurface, p Point, normal, incoming Vector, rng *rand.Rand) (Ray, Color, bool) {
+	base := ss.baseColor.baseColorAt(p)
+	switch ss.kind {
+	case MirrorMaterial:
+		return newRay(p, incoming.reflectThrough(normal)), base, true
+	case GlossyMaterial:
+		dir := phongSampleHemisphere(incoming.reflectThrough(normal), ss.glossyExponent, rng)
+		if dir.dot(normal) <= 0 {
+			return Ray{}, Color{}, false
+		}
+		return newRay(p, dir), base, true
+	default:
+		return newRay(p, cosineSampleHemisphere(normal, rng)), base, true
+	}
+}
+
+// cosineSampleHemisphere draws a direction from a cosine-weighted
+// distribution over the hemisphere around normal, the importance-sampled
+// distribution for a diffuse (Lambertian) bounce.
+func cosineSampleHemisphere(normal Vector, rng *rand.Rand) Vector {
+	u1, u2 := rng.Float64(), rng.Float64()
+	r := math.Sqrt(u1)
+	theta := 2 * math.Pi * u2
+	x := r * math.Cos(theta)
+	y := r * math.Sin(theta)
+	z := math.Sqrt(math.Max(0, 1-u1))
+	return localToWorld(x, y, z, normal)
+}
+
+// phongSampleHemisphere draws a direction from a Phong lobe of the given
+// exponent around axis, the importance-sampled distribution for a glossy
+// bounce.
+func phongSampleHemisphere(axis Vector, exponent float64, rng *rand.Rand) Vector {
+	u1, u2 := rng.Float64(), rng.Float64()
+	cosTheta := math.Pow(1-u1, 1/(exponent+1))
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * u2
+	x := sinTheta * math.Cos(phi)
+	y := sinTheta * math.Sin(phi)
+	return localToWorld(x, y, cosTheta, axis)
+}
+
+// localToWorld maps a direction given in a local frame where z is "up"
+// into world space, with axis taking the place of that local z.
+func localToWorld(x, y, z float64, axis Vector) Vector {
+	tangent, bitangent := tangentBasis(axis)
+	return tangent.scale(x).add(bitangent.scale(y)).add(axis.scale(z)).normalized()
+}
+
+// tangentBasis builds an arbitrary orthonormal (tangent, bitangent) pair
+// perpendicular to axis, used both to build a local sampling frame and to
+// flatten points on a plane into 2D texture coordinates.
+func tangentBasis(axis Vector) (tangent, bitangent Vector) {
+	up := Vector{0, 1, 0}
+	if math.Abs(axis.x) < 0.1 && math.Abs(axis.z) < 0.1 {
+		up = Vector{1, 0, 0}
+	}
+	tangent = up.cross(axis).normalized()
+	bitangent = axis.cross(tangent)
+	return
+}
+
 func main() {
 	log.SetFlags(0)
-	c := newPpmCanvas(320, 240, "raytrace")
+	integrator := flag.String("integrator", "whitted", "rendering integrator to use: whitted or path")
+	spp := flag.Int("spp", 32, "samples per pixel for the path integrator")
+	threads := flag.Int("threads", runtime.NumCPU(), "number of render worker goroutines")
+	aa := flag.Int("aa", 1, "per-axis supersampling factor for anti-aliasing (1 disables it)")
+	output := flag.String("o", "raytrace.ppm", "output file; format is chosen by extension (.ppm or .png)")
+	flag.Parse()
+
+	c, err := newCanvas(320, 240, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
 	s := newScene()
 	s.addLight(Point{30, 30, 10})
 	s.addLight(Point{-10, 100, 30})
@@ -462,5 +1528,10 @@ func main() {
 		)
 	}
 	s.addObject(newHalfSpace(Point{0, 0, 0}, UP), newCheckerboardSurface())
-	s.render(c)
+
+	if *integrator == "path" {
+		newPathTracer(s, *spp).render(c)
+	} else {
+		s.render(c, *threads, *aa)
+	}
 }